@@ -28,11 +28,10 @@ SOFTWARE.
 package clamd
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"io"
-	"net/url"
 	"strings"
+	"time"
 )
 
 // Constants representing possible scan result statuses.
@@ -48,9 +47,18 @@ const (
 )
 
 // Clamd represents a connection to a ClamAV daemon.
+//
+// Clamd is a thin, backward-compatible wrapper over Client: every method
+// goes through Client's connection pool and blocks until the command
+// completes. Note that stock clamd closes the connection after each command
+// outside of an IDSESSION (see Session), so in practice every call here
+// still dials fresh; the pool mainly helps with WithMaxOpen-style
+// concurrency limits rather than connection reuse. New code that needs
+// cancellation or deadlines should use Client directly instead.
 type Clamd struct {
-	// address is the socket address of the ClamAV daemon.
-	address string
+	// client does the actual work; Clamd's methods are non-Context
+	// convenience wrappers around Client's *Context methods.
+	client *Client
 }
 
 // Stats represents statistics about the ClamAV daemon.
@@ -65,6 +73,65 @@ type Stats struct {
 	Memstats string
 	// Queue contains information about the daemon's scan queue.
 	Queue string
+
+	// ThreadStats is Threads parsed into typed fields. It is the zero value
+	// if Threads could not be parsed.
+	ThreadStats ThreadStats
+	// QueueStats is Queue (plus its STATS continuation line) parsed into
+	// typed fields. It is the zero value if Queue could not be parsed.
+	QueueStats QueueStats
+	// Mem is Memstats parsed into typed fields. It is the zero value if
+	// Memstats could not be parsed.
+	Mem MemStats
+	// PoolStats holds one entry per thread pool clamd reported, each with
+	// its state and per-thread task/age listing. It is empty if the
+	// response contained no per-pool sections.
+	PoolStats []Pool
+}
+
+// ThreadStats is the parsed form of a clamd STATS "THREADS:" line, e.g.
+// "THREADS: live 1  idle 0 max 12 idle-timeout 30".
+type ThreadStats struct {
+	Live        int
+	Idle        int
+	Max         int
+	IdleTimeout time.Duration
+}
+
+// QueueStats is the parsed form of a clamd STATS "QUEUE:" line and its
+// "STATS <seconds>" continuation line, e.g. "QUEUE: 0 items" / "STATS 0.000000".
+type QueueStats struct {
+	Items int
+	Age   time.Duration
+}
+
+// MemStats is the parsed form of a clamd STATS "MEMSTATS:" line, e.g.
+// "MEMSTATS: heap 3.320M mmap 0.207M used 3.199M free 0.121M releasable
+// 0.000M pools 1 pools_used 1.871M pools_total 1.871M".
+type MemStats struct {
+	HeapMiB       float64
+	MmapMiB       float64
+	UsedMiB       float64
+	FreeMiB       float64
+	ReleasableMiB float64
+	Pools         int
+	PoolsUsedMiB  float64
+	PoolsTotalMiB float64
+}
+
+// Pool is one thread pool section of a clamd STATS response, e.g.
+// "PRIMARY POOL: ..." followed by its own STATE line and per-thread task/age
+// entries.
+type Pool struct {
+	Name    string
+	State   string
+	Threads []PoolThread
+}
+
+// PoolThread is a single per-thread task/age entry within a Pool section.
+type PoolThread struct {
+	Task string
+	Age  time.Duration
 }
 
 // ScanResult represents the result of a virus scan.
@@ -90,158 +157,86 @@ var EICAR = []byte(`X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FI
 // newConnection creates a new connection to the ClamAV daemon.
 // It parses the address to determine whether to use a TCP or Unix socket connection.
 // Returns a CLAMDConn and an error if any occurred.
-func (c *Clamd) newConnection() (conn *CLAMDConn, err error) {
-	var u *url.URL
-
-	if u, err = url.Parse(c.address); err != nil {
-		return
-	}
-
-	switch u.Scheme {
-	case "tcp":
-		conn, err = newCLAMDTcpConn(u.Host)
-	case "unix":
-		conn, err = newCLAMDUnixConn(u.Path)
-	default:
-		conn, err = newCLAMDUnixConn(c.address)
-	}
-
-	return
+func (c *Clamd) newConnection() (*CLAMDConn, error) {
+	return c.client.dialContext(context.Background())
 }
 
-// simpleCommand sends a command to the ClamAV daemon and returns a channel of ScanResults.
-// The channel will be closed when the response is complete.
-// Returns a channel of ScanResults and an error if any occurred.
-func (c *Clamd) simpleCommand(command string) (chan *ScanResult, error) {
-	conn, err := c.newConnection()
-	if err != nil {
-		return nil, err
-	}
+// parseStats consumes a STATS response channel into a Stats struct, both
+// the raw per-line strings kept for backward compatibility and the typed
+// fields derived from them.
+func parseStats(ch chan *ScanResult) *Stats {
+	stats := &Stats{}
+	var lines []string
 
-	err = conn.sendCommand(command)
-	if err != nil {
-		return nil, err
-	}
+	for s := range ch {
+		lines = append(lines, s.Raw)
 
-	ch, wg, err := conn.readResponse()
+		if strings.HasPrefix(s.Raw, "POOLS") {
+			stats.Pools = strings.Trim(s.Raw[6:], " ")
+		} else if strings.HasPrefix(s.Raw, "STATE") {
+			stats.State = s.Raw
+		} else if strings.HasPrefix(s.Raw, "THREADS") {
+			stats.Threads = s.Raw
+		} else if strings.HasPrefix(s.Raw, "QUEUE") {
+			stats.Queue = s.Raw
+		} else if strings.HasPrefix(s.Raw, "MEMSTATS") {
+			stats.Memstats = s.Raw
+		}
+	}
 
-	go func() {
-		wg.Wait()
-		conn.Close()
-	}()
+	stats.ThreadStats = parseThreadStats(stats.Threads)
+	stats.QueueStats = parseQueueStats(lines)
+	stats.Mem = parseMemStats(stats.Memstats)
+	stats.PoolStats = parsePoolStats(lines)
 
-	return ch, err
+	return stats
 }
 
 // Ping checks the daemon's state.
 // It sends a PING command to the ClamAV daemon and expects a PONG response.
 // Returns nil if the daemon responds with PONG, or an error otherwise.
 func (c *Clamd) Ping() error {
-	ch, err := c.simpleCommand("PING")
-	if err != nil {
-		return err
-	}
-
-	select {
-	case s := (<-ch):
-		switch s.Raw {
-		case "PONG":
-			return nil
-		default:
-			return errors.New(fmt.Sprintf("Invalid response, got %s.", s))
-		}
-	}
-
-	return nil
+	return c.client.Ping()
 }
 
 // Version returns the program and database versions of the ClamAV daemon.
 // Returns a channel of ScanResults containing the version information and an error if any occurred.
 func (c *Clamd) Version() (chan *ScanResult, error) {
-	dataArrays, err := c.simpleCommand("VERSION")
-	return dataArrays, err
+	return c.client.Version()
 }
 
 // Stats returns statistics about the ClamAV daemon.
 // It provides information about the scan queue, contents of scan queue, and memory usage.
 // Returns a Stats struct and an error if any occurred.
 func (c *Clamd) Stats() (*Stats, error) {
-	ch, err := c.simpleCommand("STATS")
-	if err != nil {
-		return nil, err
-	}
-
-	stats := &Stats{}
-
-	for s := range ch {
-		if strings.HasPrefix(s.Raw, "POOLS") {
-			stats.Pools = strings.Trim(s.Raw[6:], " ")
-		} else if strings.HasPrefix(s.Raw, "STATE") {
-			stats.State = s.Raw
-		} else if strings.HasPrefix(s.Raw, "THREADS") {
-			stats.Threads = s.Raw
-		} else if strings.HasPrefix(s.Raw, "QUEUE") {
-			stats.Queue = s.Raw
-		} else if strings.HasPrefix(s.Raw, "MEMSTATS") {
-			stats.Memstats = s.Raw
-		} else if strings.HasPrefix(s.Raw, "END") {
-		} else {
-			//	return nil, errors.New(fmt.Sprintf("Unknown response, got %s.", s))
-		}
-	}
-
-	return stats, nil
+	return c.client.Stats()
 }
 
 // Reload reloads the virus databases.
 // It sends a RELOAD command to the ClamAV daemon and expects a RELOADING response.
 // Returns nil if the daemon responds with RELOADING, or an error otherwise.
 func (c *Clamd) Reload() error {
-	ch, err := c.simpleCommand("RELOAD")
-	if err != nil {
-		return err
-	}
-
-	select {
-	case s := (<-ch):
-		switch s.Raw {
-		case "RELOADING":
-			return nil
-		default:
-			return errors.New(fmt.Sprintf("Invalid response, got %s.", s))
-		}
-	}
-
-	return nil
+	return c.client.Reload()
 }
 
 // Shutdown instructs the ClamAV daemon to shutdown.
 // Returns an error if any occurred.
 func (c *Clamd) Shutdown() error {
-	_, err := c.simpleCommand("SHUTDOWN")
-	if err != nil {
-		return err
-	}
-
-	return err
+	return c.client.Shutdown()
 }
 
 // ScanFile scans a file or directory (recursively) with archive support enabled.
 // It requires a full path to the file or directory.
 // Returns a channel of ScanResults and an error if any occurred.
 func (c *Clamd) ScanFile(path string) (chan *ScanResult, error) {
-	command := fmt.Sprintf("SCAN %s", path)
-	ch, err := c.simpleCommand(command)
-	return ch, err
+	return c.client.ScanFile(path)
 }
 
 // RawScanFile scans a file or directory (recursively) with archive and special file support disabled.
 // It requires a full path to the file or directory.
 // Returns a channel of ScanResults and an error if any occurred.
 func (c *Clamd) RawScanFile(path string) (chan *ScanResult, error) {
-	command := fmt.Sprintf("RAWSCAN %s", path)
-	ch, err := c.simpleCommand(command)
-	return ch, err
+	return c.client.RawScanFile(path)
 }
 
 // MultiScanFile scans a file in a standard way or scans a directory (recursively) using multiple threads.
@@ -249,9 +244,7 @@ func (c *Clamd) RawScanFile(path string) (chan *ScanResult, error) {
 // It requires a full path to the file or directory.
 // Returns a channel of ScanResults and an error if any occurred.
 func (c *Clamd) MultiScanFile(path string) (chan *ScanResult, error) {
-	command := fmt.Sprintf("MULTISCAN %s", path)
-	ch, err := c.simpleCommand(command)
-	return ch, err
+	return c.client.MultiScanFile(path)
 }
 
 // ContScanFile scans a file or directory (recursively) with archive support enabled.
@@ -259,9 +252,7 @@ func (c *Clamd) MultiScanFile(path string) (chan *ScanResult, error) {
 // It requires a full path to the file or directory.
 // Returns a channel of ScanResults and an error if any occurred.
 func (c *Clamd) ContScanFile(path string) (chan *ScanResult, error) {
-	command := fmt.Sprintf("CONTSCAN %s", path)
-	ch, err := c.simpleCommand(command)
-	return ch, err
+	return c.client.ContScanFile(path)
 }
 
 // AllMatchScanFile scans a file or directory (recursively) with archive support enabled.
@@ -269,9 +260,7 @@ func (c *Clamd) ContScanFile(path string) (chan *ScanResult, error) {
 // It requires a full path to the file or directory.
 // Returns a channel of ScanResults and an error if any occurred.
 func (c *Clamd) AllMatchScanFile(path string) (chan *ScanResult, error) {
-	command := fmt.Sprintf("ALLMATCHSCAN %s", path)
-	ch, err := c.simpleCommand(command)
-	return ch, err
+	return c.client.AllMatchScanFile(path)
 }
 
 // ScanStream scans a stream of data.
@@ -285,56 +274,14 @@ func (c *Clamd) AllMatchScanFile(path string) (chan *ScanResult, error) {
 // The abort channel can be used to abort the scan.
 // Returns a channel of ScanResults and an error if any occurred.
 func (c *Clamd) ScanStream(r io.Reader, abort chan bool) (chan *ScanResult, error) {
-	conn, err := c.newConnection()
-	if err != nil {
-		return nil, err
-	}
-
-	go func() {
-		for {
-			_, allowRunning := <-abort
-			if !allowRunning {
-				break
-			}
-		}
-		conn.Close()
-	}()
-
-	conn.sendCommand("INSTREAM")
-
-	for {
-		buf := make([]byte, CHUNK_SIZE)
-
-		nr, err := r.Read(buf)
-		if nr > 0 {
-			conn.sendChunk(buf[0:nr])
-		}
-
-		if err != nil {
-			break
-		}
-
-	}
-
-	err = conn.sendEOF()
-	if err != nil {
-		return nil, err
-	}
-
-	ch, wg, err := conn.readResponse()
-
-	go func() {
-		wg.Wait()
-		conn.Close()
-	}()
-
-	return ch, nil
+	return c.client.ScanStream(r, abort)
 }
 
 // NewClamd creates a new Clamd instance with the specified address.
 // The address can be a TCP address (tcp://host:port) or a Unix socket path.
+// opts configures the underlying Client, e.g. WithTLSConfig to wrap TCP
+// connections in TLS for deployments that front clamd with stunnel.
 // Returns a new Clamd instance.
-func NewClamd(address string) *Clamd {
-	clamd := &Clamd{address: address}
-	return clamd
+func NewClamd(address string, opts ...ClientOption) *Clamd {
+	return &Clamd{client: NewClient(address, opts...)}
 }
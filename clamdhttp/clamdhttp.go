@@ -0,0 +1,331 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+
+The MIT License (MIT)
+Copyright (c) 2013 DutchCoders <http://github.com/dutchcoders/>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package clamdhttp provides an HTTP middleware and reverse proxy that scan
+// multipart/form-data uploads through clamd before they reach the next
+// handler (or an upstream server), blocking the request on a FOUND verdict.
+package clamdhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+
+	"github.com/IntelXLabs-LLC/go-clamd"
+)
+
+// Verdict describes the scan result for a single multipart part.
+type Verdict struct {
+	Part        string `json:"part"`
+	Status      string `json:"status"`
+	Description string `json:"description,omitempty"`
+}
+
+// Options configures Middleware and ReverseProxy.
+type Options struct {
+	// MaxBodySize caps the size, in bytes, of any single multipart part.
+	// Parts larger than this are rejected with a 400 before they finish
+	// scanning. Zero (the default) means no limit.
+	MaxBodySize int64
+
+	// NotifyHeader, if set, makes the middleware forward the scan verdict
+	// to the next handler as this request header instead of blocking the
+	// request on FOUND/ERROR. The header is only set when a part did not
+	// come back OK.
+	NotifyHeader string
+
+	// ContentTypes restricts scanning to multipart parts whose own
+	// Content-Type appears in this list (e.g. "image/png"). Parts whose
+	// Content-Type isn't listed are copied into the rebuilt body unscanned.
+	// A nil/empty list scans every part.
+	ContentTypes []string
+
+	// Logger receives one line per scanned part. Defaults to log.Default().
+	Logger *log.Logger
+
+	// OnBlocked writes the response for a FOUND/ERROR verdict when
+	// NotifyHeader is empty. It defaults to writeJSONVerdict, which writes
+	// verdict as a JSON body with the given status code.
+	OnBlocked func(w http.ResponseWriter, status int, v Verdict)
+}
+
+func (o Options) logger() *log.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return log.Default()
+}
+
+func (o Options) onBlocked() func(http.ResponseWriter, int, Verdict) {
+	if o.OnBlocked != nil {
+		return o.OnBlocked
+	}
+	return writeJSONVerdict
+}
+
+func writeJSONVerdict(w http.ResponseWriter, status int, v Verdict) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Middleware returns an http.Handler wrapper that scans every
+// multipart/form-data part of matching requests through c before calling
+// the next handler. Clean uploads are re-assembled into a fresh multipart
+// body and passed through unchanged; infected or unscannable uploads get a
+// 400 or 502 JSON response unless Options.NotifyHeader is set, in which
+// case the verdict is forwarded as a header instead of blocking.
+func Middleware(c *clamd.Clamd, opts Options) func(http.Handler) http.Handler {
+	logger := opts.logger()
+	onBlocked := opts.onBlocked()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			boundary, ok := multipartBoundary(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rebuilt, verdict, err := scanMultipart(c, r.Body, boundary, opts.MaxBodySize, opts.NotifyHeader != "", opts.ContentTypes, logger)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			if verdict != nil && opts.NotifyHeader == "" {
+				status := http.StatusBadRequest
+				if verdict.Status == clamd.RES_ERROR {
+					status = http.StatusBadGateway
+				}
+				onBlocked(w, status, *verdict)
+				return
+			}
+
+			r.Body = io.NopCloser(rebuilt.body)
+			r.ContentLength = int64(rebuilt.body.Len())
+			r.Header.Set("Content-Type", rebuilt.contentType)
+
+			if verdict != nil {
+				r.Header.Set(opts.NotifyHeader, verdict.Status)
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ReverseProxy returns an http.Handler that scans multipart uploads through
+// c, exactly as Middleware does, before forwarding clean requests to
+// upstream.
+func ReverseProxy(c *clamd.Clamd, upstream *url.URL, opts Options) http.Handler {
+	proxy := httputil.NewSingleHostReverseProxy(upstream)
+	return Middleware(c, opts)(proxy)
+}
+
+// multipartBoundary returns the multipart boundary of r, and whether r is a
+// request this middleware should scan at all. Options.ContentTypes is
+// checked later, per part, since it targets the content of each part rather
+// than the overall multipart/form-data envelope.
+func multipartBoundary(r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut && r.Method != http.MethodPatch {
+		return "", false
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return "", false
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" {
+		return "", false
+	}
+
+	return boundary, true
+}
+
+func contains(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// partMediaType returns a part's own Content-Type, stripped of any
+// parameters, defaulting to "application/octet-stream" as mime/multipart
+// does when the header is absent.
+func partMediaType(part *multipart.Part) string {
+	contentType := part.Header.Get("Content-Type")
+	if contentType == "" {
+		return "application/octet-stream"
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType
+	}
+	return mediaType
+}
+
+// copyPart copies part verbatim into mw without scanning it.
+func copyPart(mw *multipart.Writer, part *multipart.Part) error {
+	dst, err := mw.CreatePart(part.Header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, part)
+	return err
+}
+
+// rebuiltBody is the clean, re-assembled multipart body produced once every
+// part of the original request has scanned OK.
+type rebuiltBody struct {
+	body        *bytes.Buffer
+	contentType string
+}
+
+// scanMultipart streams each part of a multipart/form-data body in body
+// through c.ScanStream, teeing the same bytes into a freshly written
+// multipart body. It stops at the first non-OK verdict, unless keepGoing
+// is set (NotifyHeader mode), in which case it keeps scanning and copying
+// every remaining part so the rebuilt body stays complete; only the first
+// non-OK verdict encountered is returned. Parts whose own Content-Type isn't
+// in contentTypes (when non-empty) are copied unscanned.
+func scanMultipart(c *clamd.Clamd, body io.Reader, boundary string, maxBodySize int64, keepGoing bool, contentTypes []string, logger *log.Logger) (*rebuiltBody, *Verdict, error) {
+	mr := multipart.NewReader(body, boundary)
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	var verdict *Verdict
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(contentTypes) > 0 && !contains(contentTypes, partMediaType(part)) {
+			err := copyPart(mw, part)
+			part.Close()
+			if err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		v, err := scanPart(c, mw, part, maxBodySize)
+		part.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		logger.Printf("clamdhttp: scanned part %q: %s", v.Part, v.Status)
+
+		if v.Status != clamd.RES_OK {
+			if verdict == nil {
+				verdict = &v
+			}
+			if !keepGoing {
+				break
+			}
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	return &rebuiltBody{body: &buf, contentType: mw.FormDataContentType()}, verdict, nil
+}
+
+// scanPart tees a single multipart part into mw (the rebuilt body) and into
+// a ScanStream call, blocking until clamd returns a verdict for it.
+func scanPart(c *clamd.Clamd, mw *multipart.Writer, part *multipart.Part, maxBodySize int64) (Verdict, error) {
+	dst, err := mw.CreatePart(part.Header)
+	if err != nil {
+		return Verdict{}, err
+	}
+
+	pr, pw := io.Pipe()
+
+	type scanOutcome struct {
+		result *clamd.ScanResult
+		err    error
+	}
+	outcome := make(chan scanOutcome, 1)
+
+	go func() {
+		ch, err := c.ScanStream(pr, nil)
+		if err != nil {
+			pr.CloseWithError(err)
+			outcome <- scanOutcome{err: err}
+			return
+		}
+		outcome <- scanOutcome{result: <-ch}
+	}()
+
+	var src io.Reader = part
+	if maxBodySize > 0 {
+		src = io.LimitReader(part, maxBodySize+1)
+	}
+
+	n, copyErr := io.Copy(io.MultiWriter(dst, pw), src)
+	pw.Close()
+
+	if copyErr != nil {
+		<-outcome
+		return Verdict{}, copyErr
+	}
+
+	if maxBodySize > 0 && n > maxBodySize {
+		<-outcome
+		return Verdict{}, fmt.Errorf("clamdhttp: part %q exceeds max body size of %d bytes", part.FormName(), maxBodySize)
+	}
+
+	o := <-outcome
+	if o.err != nil {
+		return Verdict{}, o.err
+	}
+
+	return Verdict{
+		Part:        part.FormName(),
+		Status:      o.result.Status,
+		Description: o.result.Description,
+	}, nil
+}
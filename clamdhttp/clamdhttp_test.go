@@ -0,0 +1,337 @@
+package clamdhttp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"path/filepath"
+	"testing"
+
+	"github.com/IntelXLabs-LLC/go-clamd"
+)
+
+// startFakeClamd runs a minimal clamd stand-in that understands just enough
+// of the INSTREAM protocol to answer ScanStream calls: it reads
+// length-prefixed chunks until the zero-length terminator, and replies
+// FOUND if the reassembled stream contains clamd.EICAR, OK otherwise.
+func startFakeClamd(t *testing.T) string {
+	t.Helper()
+
+	sock := filepath.Join(t.TempDir(), "clamd.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveFakeClamdConn(conn)
+		}
+	}()
+
+	t.Cleanup(func() { ln.Close() })
+
+	return "unix://" + sock
+}
+
+func serveFakeClamdConn(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+
+	// Consume the "nINSTREAM\n" command line.
+	if _, err := reader.ReadString('\n'); err != nil {
+		return
+	}
+
+	var stream bytes.Buffer
+	for {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(reader, lenBuf[:]); err != nil {
+			return
+		}
+
+		n := binary.BigEndian.Uint32(lenBuf[:])
+		if n == 0 {
+			break
+		}
+
+		if _, err := io.CopyN(&stream, reader, int64(n)); err != nil {
+			return
+		}
+	}
+
+	if bytes.Contains(stream.Bytes(), clamd.EICAR) {
+		fmt.Fprintf(conn, "stream: Eicar-Test-Signature FOUND\n")
+	} else {
+		fmt.Fprintf(conn, "stream: OK\n")
+	}
+}
+
+func newMultipartRequest(t *testing.T, fields map[string]string) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	for name, content := range fields {
+		part, err := mw.CreateFormFile(name, name+".txt")
+		if err != nil {
+			t.Fatalf("CreateFormFile: %v", err)
+		}
+		if _, err := part.Write([]byte(content)); err != nil {
+			t.Fatalf("write part: %v", err)
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestMiddlewareBlocksEicar(t *testing.T) {
+	c := clamd.NewClamd(startFakeClamd(t))
+
+	var nextCalled bool
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nextCalled = true
+	})
+
+	handler := Middleware(c, Options{})(next)
+
+	req := newMultipartRequest(t, map[string]string{"file": string(clamd.EICAR)})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if nextCalled {
+		t.Fatal("next handler should not run for an infected upload")
+	}
+
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected status %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	if !bytes.Contains(rec.Body.Bytes(), []byte("FOUND")) {
+		t.Fatalf("expected verdict body to mention FOUND, got %q", rec.Body.String())
+	}
+}
+
+func TestMiddlewareForwardsCleanUpload(t *testing.T) {
+	c := clamd.NewClamd(startFakeClamd(t))
+
+	var forwardedBody string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Fatalf("MultipartReader: %v", err)
+		}
+
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+
+		data, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatalf("read part: %v", err)
+		}
+
+		forwardedBody = string(data)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := Middleware(c, Options{})(next)
+
+	req := newMultipartRequest(t, map[string]string{"file": "hello, world"})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	if forwardedBody != "hello, world" {
+		t.Fatalf("expected forwarded part to read %q, got %q", "hello, world", forwardedBody)
+	}
+}
+
+func TestMiddlewareNotifyHeaderKeepsAllParts(t *testing.T) {
+	c := clamd.NewClamd(startFakeClamd(t))
+
+	var forwardedParts []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Fatalf("MultipartReader: %v", err)
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("NextPart: %v", err)
+			}
+
+			data, err := io.ReadAll(part)
+			if err != nil {
+				t.Fatalf("read part: %v", err)
+			}
+			forwardedParts = append(forwardedParts, string(data))
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := Middleware(c, Options{NotifyHeader: "X-Clamd-Verdict"})(next)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, content := range []string{string(clamd.EICAR), "clean-a", "clean-b"} {
+		part, err := mw.CreateFormFile(fmt.Sprintf("file%d", i), "file.txt")
+		if err != nil {
+			t.Fatalf("CreateFormFile: %v", err)
+		}
+		if _, err := part.Write([]byte(content)); err != nil {
+			t.Fatalf("write part: %v", err)
+		}
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	if len(forwardedParts) != 3 {
+		t.Fatalf("expected all 3 parts to reach the next handler, got %d: %v", len(forwardedParts), forwardedParts)
+	}
+	if forwardedParts[1] != "clean-a" || forwardedParts[2] != "clean-b" {
+		t.Fatalf("expected parts after the infected one to survive intact, got %v", forwardedParts)
+	}
+}
+
+func TestMiddlewareContentTypesSkipsNonMatchingParts(t *testing.T) {
+	c := clamd.NewClamd(startFakeClamd(t))
+
+	var forwardedParts []string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mr, err := r.MultipartReader()
+		if err != nil {
+			t.Fatalf("MultipartReader: %v", err)
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("NextPart: %v", err)
+			}
+
+			data, err := io.ReadAll(part)
+			if err != nil {
+				t.Fatalf("read part: %v", err)
+			}
+			forwardedParts = append(forwardedParts, string(data))
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := Middleware(c, Options{ContentTypes: []string{"image/png"}})(next)
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	textPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="notes"; filename="notes.txt"`},
+		"Content-Type":        {"text/plain"},
+	})
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	// An EICAR string in a part outside ContentTypes must pass through
+	// unscanned rather than blocking the request.
+	if _, err := textPart.Write(clamd.EICAR); err != nil {
+		t.Fatalf("write part: %v", err)
+	}
+
+	imgPart, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {`form-data; name="avatar"; filename="avatar.png"`},
+		"Content-Type":        {"image/png"},
+	})
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	if _, err := imgPart.Write([]byte("clean-image-bytes")); err != nil {
+		t.Fatalf("write part: %v", err)
+	}
+
+	if err := mw.Close(); err != nil {
+		t.Fatalf("close writer: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d (body=%q)", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	if len(forwardedParts) != 2 {
+		t.Fatalf("expected both parts to reach the next handler, got %d: %v", len(forwardedParts), forwardedParts)
+	}
+	if forwardedParts[0] != string(clamd.EICAR) || forwardedParts[1] != "clean-image-bytes" {
+		t.Fatalf("unexpected forwarded parts: %v", forwardedParts)
+	}
+}
+
+func TestMiddlewareNotifyHeaderForwardsInsteadOfBlocking(t *testing.T) {
+	c := clamd.NewClamd(startFakeClamd(t))
+
+	var gotHeader string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("X-Clamd-Verdict")
+		w.WriteHeader(http.StatusOK)
+	})
+
+	handler := Middleware(c, Options{NotifyHeader: "X-Clamd-Verdict"})(next)
+
+	req := newMultipartRequest(t, map[string]string{"file": string(clamd.EICAR)})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	if gotHeader != clamd.RES_FOUND {
+		t.Fatalf("expected %s header to be %q, got %q", "X-Clamd-Verdict", clamd.RES_FOUND, gotHeader)
+	}
+}
@@ -0,0 +1,572 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+
+The MIT License (MIT)
+Copyright (c) 2013 DutchCoders <http://github.com/dutchcoders/>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package clamd provides a client for the ClamAV daemon (clamd).
+// This file implements Client, a connection-pooled, context.Context-aware
+// counterpart to Clamd. Clamd is kept around as a thin wrapper over Client
+// for backward compatibility.
+package clamd
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxIdle is the default number of idle connections a Client keeps
+// around for reuse when no WithMaxIdle option is given.
+const DefaultMaxIdle = 2
+
+// idleConn is a pooled connection together with the time it was returned to
+// the pool, so the pool can evict it once it has sat idle past IdleTimeout.
+type idleConn struct {
+	conn       *CLAMDConn
+	returnedAt time.Time
+}
+
+// Client is a pooled, context-aware client for the ClamAV daemon, and every
+// command has a *Context variant that honors the caller's context.Context
+// for cancellation and deadlines. A connection goes back to the idle pool
+// only after its response has been read to the end of the stream, that is,
+// once the daemon has closed its side; stock clamd does so after each
+// command sent outside IDSESSION, so a pooled connection fails
+// healthCheck's PING on checkout and is replaced by a fresh dial. Session
+// does not use this pool. WithMaxOpen/WithMaxIdle still bound concurrency
+// and resource use, but they do not avoid the cost of a fresh connection
+// per command.
+type Client struct {
+	address      string
+	maxIdle      int
+	idleTimeout  time.Duration
+	sem          chan struct{}
+	maxLineBytes int
+	tlsConfig    *tls.Config
+
+	mu   sync.Mutex
+	idle []*idleConn
+}
+
+// ClientOption configures a Client created by NewClient.
+type ClientOption func(*Client)
+
+// WithMaxIdle sets the maximum number of idle connections the Client keeps
+// open for reuse. Connections returned once this limit is reached are
+// closed instead of pooled. The default is DefaultMaxIdle.
+func WithMaxIdle(n int) ClientOption {
+	return func(cl *Client) { cl.maxIdle = n }
+}
+
+// WithMaxOpen caps the number of connections the Client will have checked
+// out (in use) at once; it does not bound connections sitting idle in the
+// pool, so with WithMaxIdle also set, total open sockets can reach
+// MaxOpen+MaxIdle. Acquiring a connection beyond this limit blocks until one
+// is returned or the caller's context is done. A value <= 0 (the default)
+// leaves the number of concurrently checked-out connections unbounded.
+func WithMaxOpen(n int) ClientOption {
+	return func(cl *Client) {
+		if n > 0 {
+			cl.sem = make(chan struct{}, n)
+		} else {
+			cl.sem = nil
+		}
+	}
+}
+
+// WithIdleTimeout sets how long a pooled connection may sit idle before it
+// is closed instead of being reused. A value <= 0 (the default) means idle
+// connections are never evicted on age alone.
+func WithIdleTimeout(d time.Duration) ClientOption {
+	return func(cl *Client) { cl.idleTimeout = d }
+}
+
+// WithMaxLineBytes caps the length of a single response line the Client
+// will accept before failing the command with RES_PARSE_ERROR instead of
+// growing its read buffer without bound. A value <= 0 (the default) means
+// DefaultMaxLineBytes.
+func WithMaxLineBytes(n int) ClientOption {
+	return func(cl *Client) { cl.maxLineBytes = n }
+}
+
+// WithTLSConfig wraps TCP connections to clamd in tls.Client using cfg,
+// for deployments that front clamd with stunnel or another TLS-terminating
+// proxy. It has no effect on Unix socket connections.
+func WithTLSConfig(cfg *tls.Config) ClientOption {
+	return func(cl *Client) { cl.tlsConfig = cfg }
+}
+
+// NewClient creates a new pooled Client for the ClamAV daemon at address.
+// The address can be a TCP address (tcp://host:port) or a Unix socket path,
+// exactly as with NewClamd.
+func NewClient(address string, opts ...ClientOption) *Client {
+	cl := &Client{
+		address: address,
+		maxIdle: DefaultMaxIdle,
+	}
+
+	for _, opt := range opts {
+		opt(cl)
+	}
+
+	return cl
+}
+
+// dialContext opens a fresh connection to the daemon, honoring ctx for both
+// dialing and, once connected, is otherwise unmanaged: callers are
+// responsible for setting read/write deadlines and closing or releasing it.
+func (cl *Client) dialContext(ctx context.Context) (*CLAMDConn, error) {
+	u, err := url.Parse(cl.address)
+	if err != nil {
+		return nil, err
+	}
+
+	network, addr := "unix", cl.address
+	switch u.Scheme {
+	case "tcp":
+		network, addr = "tcp", u.Host
+	case "unix":
+		network, addr = "unix", u.Path
+	}
+
+	dialer := net.Dialer{}
+	if _, ok := ctx.Deadline(); !ok && network == "tcp" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, TCP_TIMEOUT)
+		defer cancel()
+	}
+
+	rawConn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	unixConn, _ := rawConn.(*net.UnixConn)
+
+	var conn net.Conn = rawConn
+	if network == "tcp" && cl.tlsConfig != nil {
+		tlsConn := tls.Client(rawConn, cl.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			rawConn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	return &CLAMDConn{Conn: conn, unix: unixConn, maxLineBytes: cl.maxLineBytes}, nil
+}
+
+// acquire returns a connection to the daemon, preferring a healthy pooled
+// connection over dialing a new one. If WithMaxOpen was given, acquire
+// blocks until a slot is free or ctx is done. A slot bounds the number of
+// connections concurrently checked out this way; it is not held by a
+// connection sitting idle in the pool (see WithMaxOpen), so total open
+// sockets can reach MaxOpen+MaxIdle, not just MaxOpen.
+func (cl *Client) acquire(ctx context.Context) (*CLAMDConn, error) {
+	if cl.sem != nil {
+		select {
+		case cl.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	for {
+		cl.mu.Lock()
+		n := len(cl.idle)
+		if n == 0 {
+			cl.mu.Unlock()
+			break
+		}
+		ic := cl.idle[n-1]
+		cl.idle = cl.idle[:n-1]
+		cl.mu.Unlock()
+
+		if cl.idleTimeout > 0 && time.Since(ic.returnedAt) > cl.idleTimeout {
+			ic.conn.Close()
+			continue
+		}
+
+		if !cl.healthCheck(ic.conn) {
+			ic.conn.Close()
+			continue
+		}
+
+		return ic.conn, nil
+	}
+
+	conn, err := cl.dialContext(ctx)
+	if err != nil {
+		cl.releaseSlot()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// release frees conn's WithMaxOpen slot and either returns it to the idle
+// pool or closes it, if broken is true or the pool is already at capacity.
+// A connection parked in the idle pool does not hold a slot; see
+// WithMaxOpen.
+func (cl *Client) release(conn *CLAMDConn, broken bool) {
+	if broken {
+		conn.Close()
+		cl.releaseSlot()
+		return
+	}
+
+	cl.mu.Lock()
+	if len(cl.idle) < cl.maxIdle {
+		cl.idle = append(cl.idle, &idleConn{conn: conn, returnedAt: time.Now()})
+		cl.mu.Unlock()
+	} else {
+		cl.mu.Unlock()
+		conn.Close()
+	}
+
+	cl.releaseSlot()
+}
+
+// releaseSlot frees up one WithMaxOpen slot, if the Client has a bound.
+func (cl *Client) releaseSlot() {
+	if cl.sem != nil {
+		<-cl.sem
+	}
+}
+
+// healthCheck sends a cheap PING down a pooled connection before handing it
+// back out, so a connection clamd (or a NAT/load balancer) silently dropped
+// while idle doesn't surface as a confusing failure on the caller's command.
+func (cl *Client) healthCheck(conn *CLAMDConn) bool {
+	conn.SetDeadline(time.Now().Add(TCP_TIMEOUT))
+	defer conn.SetDeadline(time.Time{})
+
+	if err := conn.sendCommand("PING"); err != nil {
+		return false
+	}
+
+	ch, wg, err := conn.readResponse()
+	if err != nil {
+		return false
+	}
+
+	s, ok := <-ch
+	wg.Wait()
+
+	return ok && s.Raw == "PONG"
+}
+
+// isRetryable reports whether err looks like it came from a connection that
+// was already broken (e.g. clamd closed an idle connection out from under
+// us), in which case the command is worth retrying once on a fresh
+// connection rather than failing outright.
+func isRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	if errors.Is(err, io.EOF) {
+		return true
+	}
+
+	msg := err.Error()
+	return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset")
+}
+
+// simpleCommandContext sends command over a pooled connection and returns a
+// channel of ScanResults, retrying once on a fresh connection if the first
+// attempt fails with a broken-connection error.
+func (cl *Client) simpleCommandContext(ctx context.Context, command string) (chan *ScanResult, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < 2; attempt++ {
+		conn, err := cl.acquire(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		if dl, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(dl)
+		}
+
+		if err := conn.sendCommand(command); err != nil {
+			cl.release(conn, true)
+			lastErr = err
+			if attempt == 0 && isRetryable(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		ch, wg, err := conn.readResponse()
+		if err != nil {
+			cl.release(conn, true)
+			return nil, err
+		}
+
+		go func() {
+			wg.Wait()
+			conn.SetDeadline(time.Time{})
+			cl.release(conn, false)
+		}()
+
+		return ch, nil
+	}
+
+	return nil, lastErr
+}
+
+// PingContext checks the daemon's state, honoring ctx for cancellation.
+// Returns nil if the daemon responds with PONG, or an error otherwise.
+func (cl *Client) PingContext(ctx context.Context) error {
+	ch, err := cl.simpleCommandContext(ctx, "PING")
+	if err != nil {
+		return err
+	}
+
+	s := <-ch
+	if s.Raw != "PONG" {
+		return fmt.Errorf("invalid response, got %s", s.Raw)
+	}
+
+	return nil
+}
+
+// Ping is equivalent to PingContext(context.Background()).
+func (cl *Client) Ping() error {
+	return cl.PingContext(context.Background())
+}
+
+// VersionContext returns the program and database versions of the daemon,
+// honoring ctx for cancellation.
+// Returns a channel of ScanResults containing the version information.
+func (cl *Client) VersionContext(ctx context.Context) (chan *ScanResult, error) {
+	return cl.simpleCommandContext(ctx, "VERSION")
+}
+
+// Version is equivalent to VersionContext(context.Background()).
+func (cl *Client) Version() (chan *ScanResult, error) {
+	return cl.VersionContext(context.Background())
+}
+
+// StatsContext returns statistics about the daemon, honoring ctx for
+// cancellation.
+// Returns a Stats struct and an error if any occurred.
+func (cl *Client) StatsContext(ctx context.Context) (*Stats, error) {
+	ch, err := cl.simpleCommandContext(ctx, "STATS")
+	if err != nil {
+		return nil, err
+	}
+
+	return parseStats(ch), nil
+}
+
+// Stats is equivalent to StatsContext(context.Background()).
+func (cl *Client) Stats() (*Stats, error) {
+	return cl.StatsContext(context.Background())
+}
+
+// ReloadContext reloads the virus databases, honoring ctx for cancellation.
+// Returns nil if the daemon responds with RELOADING, or an error otherwise.
+func (cl *Client) ReloadContext(ctx context.Context) error {
+	ch, err := cl.simpleCommandContext(ctx, "RELOAD")
+	if err != nil {
+		return err
+	}
+
+	s := <-ch
+	if s.Raw != "RELOADING" {
+		return fmt.Errorf("invalid response, got %s", s.Raw)
+	}
+
+	return nil
+}
+
+// Reload is equivalent to ReloadContext(context.Background()).
+func (cl *Client) Reload() error {
+	return cl.ReloadContext(context.Background())
+}
+
+// ShutdownContext instructs the daemon to shut down, honoring ctx for
+// cancellation.
+// Returns an error if any occurred.
+func (cl *Client) ShutdownContext(ctx context.Context) error {
+	_, err := cl.simpleCommandContext(ctx, "SHUTDOWN")
+	return err
+}
+
+// Shutdown is equivalent to ShutdownContext(context.Background()).
+func (cl *Client) Shutdown() error {
+	return cl.ShutdownContext(context.Background())
+}
+
+// ScanFileContext scans a file or directory (recursively) with archive
+// support enabled, honoring ctx for cancellation.
+// Returns a channel of ScanResults and an error if any occurred.
+func (cl *Client) ScanFileContext(ctx context.Context, path string) (chan *ScanResult, error) {
+	return cl.simpleCommandContext(ctx, fmt.Sprintf("SCAN %s", path))
+}
+
+// ScanFile is equivalent to ScanFileContext(context.Background(), path).
+func (cl *Client) ScanFile(path string) (chan *ScanResult, error) {
+	return cl.ScanFileContext(context.Background(), path)
+}
+
+// RawScanFileContext scans a file or directory (recursively) with archive
+// and special file support disabled, honoring ctx for cancellation.
+// Returns a channel of ScanResults and an error if any occurred.
+func (cl *Client) RawScanFileContext(ctx context.Context, path string) (chan *ScanResult, error) {
+	return cl.simpleCommandContext(ctx, fmt.Sprintf("RAWSCAN %s", path))
+}
+
+// RawScanFile is equivalent to RawScanFileContext(context.Background(), path).
+func (cl *Client) RawScanFile(path string) (chan *ScanResult, error) {
+	return cl.RawScanFileContext(context.Background(), path)
+}
+
+// MultiScanFileContext scans a file or a directory (recursively) using
+// multiple threads, honoring ctx for cancellation.
+// Returns a channel of ScanResults and an error if any occurred.
+func (cl *Client) MultiScanFileContext(ctx context.Context, path string) (chan *ScanResult, error) {
+	return cl.simpleCommandContext(ctx, fmt.Sprintf("MULTISCAN %s", path))
+}
+
+// MultiScanFile is equivalent to MultiScanFileContext(context.Background(), path).
+func (cl *Client) MultiScanFile(path string) (chan *ScanResult, error) {
+	return cl.MultiScanFileContext(context.Background(), path)
+}
+
+// ContScanFileContext scans a file or directory (recursively) without
+// stopping at the first match, honoring ctx for cancellation.
+// Returns a channel of ScanResults and an error if any occurred.
+func (cl *Client) ContScanFileContext(ctx context.Context, path string) (chan *ScanResult, error) {
+	return cl.simpleCommandContext(ctx, fmt.Sprintf("CONTSCAN %s", path))
+}
+
+// ContScanFile is equivalent to ContScanFileContext(context.Background(), path).
+func (cl *Client) ContScanFile(path string) (chan *ScanResult, error) {
+	return cl.ContScanFileContext(context.Background(), path)
+}
+
+// AllMatchScanFileContext scans a file or directory (recursively) without
+// stopping at the first match, reporting all matches, honoring ctx for
+// cancellation.
+// Returns a channel of ScanResults and an error if any occurred.
+func (cl *Client) AllMatchScanFileContext(ctx context.Context, path string) (chan *ScanResult, error) {
+	return cl.simpleCommandContext(ctx, fmt.Sprintf("ALLMATCHSCAN %s", path))
+}
+
+// AllMatchScanFile is equivalent to AllMatchScanFileContext(context.Background(), path).
+func (cl *Client) AllMatchScanFile(path string) (chan *ScanResult, error) {
+	return cl.AllMatchScanFileContext(context.Background(), path)
+}
+
+// ScanStreamContext scans a stream of data, honoring ctx for cancellation
+// of the upload in addition to the abort channel supported by ScanStream.
+// See ScanStream for the wire format.
+// Returns a channel of ScanResults and an error if any occurred.
+func (cl *Client) ScanStreamContext(ctx context.Context, r io.Reader, abort chan bool) (chan *ScanResult, error) {
+	conn, err := cl.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if dl, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(dl)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+			case _, stillRunning := <-abort:
+				if stillRunning {
+					continue
+				}
+			}
+			break
+		}
+		conn.Close()
+	}()
+	stopWatcher := func() { close(done) }
+
+	if err := conn.sendCommand("INSTREAM"); err != nil {
+		stopWatcher()
+		cl.release(conn, true)
+		return nil, err
+	}
+
+	for {
+		buf := make([]byte, CHUNK_SIZE)
+
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			if werr := conn.sendChunk(buf[0:nr]); werr != nil {
+				stopWatcher()
+				cl.release(conn, true)
+				return nil, werr
+			}
+		}
+
+		if rerr != nil {
+			break
+		}
+	}
+
+	if err := conn.sendEOF(); err != nil {
+		stopWatcher()
+		cl.release(conn, true)
+		return nil, err
+	}
+
+	ch, wg, err := conn.readResponse()
+	if err != nil {
+		stopWatcher()
+		cl.release(conn, true)
+		return nil, err
+	}
+
+	go func() {
+		wg.Wait()
+		stopWatcher()
+		conn.SetDeadline(time.Time{})
+		cl.release(conn, false)
+	}()
+
+	return ch, nil
+}
+
+// ScanStream is equivalent to ScanStreamContext(context.Background(), r, abort).
+func (cl *Client) ScanStream(r io.Reader, abort chan bool) (chan *ScanResult, error) {
+	return cl.ScanStreamContext(context.Background(), r, abort)
+}
@@ -30,7 +30,6 @@ package clamd
 import (
 	"bufio"
 	"fmt"
-	"io"
 	"net"
 	"regexp"
 	"strconv"
@@ -51,10 +50,31 @@ var resultRegex = regexp.MustCompile(
 	`^(?P<path>[^:]+): ((?P<desc>[^:]+)(\((?P<virhash>([^:]+)):(?P<virsize>\d+)\))? )?(?P<status>FOUND|ERROR|OK)$`,
 )
 
+// DefaultMaxLineBytes is the maximum length of a single response line
+// readResponse will accept before failing with RES_PARSE_ERROR, for
+// connections that don't set CLAMDConn.maxLineBytes explicitly.
+const DefaultMaxLineBytes = 64 * 1024
+
 // CLAMDConn represents a connection to the ClamAV daemon.
 // It embeds net.Conn to provide the basic connection functionality.
 type CLAMDConn struct {
 	net.Conn
+
+	// unix holds the same connection as Conn, re-typed as a *net.UnixConn,
+	// when the connection was dialed over a Unix socket. It is nil for TCP
+	// connections, and is used by commands such as FILDES that need to pass
+	// ancillary data (e.g. SCM_RIGHTS) that only a Unix socket supports.
+	unix *net.UnixConn
+
+	// maxLineBytes caps how long a single response line may be before
+	// readResponse gives up on it. Zero means DefaultMaxLineBytes.
+	maxLineBytes int
+}
+
+// UnixConn returns the connection as a *net.UnixConn, or nil if it was not
+// dialed over a Unix socket.
+func (conn *CLAMDConn) UnixConn() *net.UnixConn {
+	return conn.unix
 }
 
 // sendCommand sends a command to the ClamAV daemon.
@@ -67,6 +87,18 @@ func (conn *CLAMDConn) sendCommand(command string) error {
 	return err
 }
 
+// sendZCommand sends a command to the ClamAV daemon using the null-terminated
+// "z" prefix instead of the newline-terminated "n" prefix used by sendCommand.
+// This is the framing required inside an IDSESSION, where responses must be
+// unambiguously split on NUL rather than newline.
+// Returns an error if any occurred.
+func (conn *CLAMDConn) sendZCommand(command string) error {
+	commandBytes := []byte(fmt.Sprintf("z%s\x00", command))
+
+	_, err := conn.Write(commandBytes)
+	return err
+}
+
 // sendEOF sends an EOF (end of file) marker to the ClamAV daemon.
 // This is used to terminate a stream of data.
 // Returns an error if any occurred.
@@ -79,34 +111,47 @@ func (conn *CLAMDConn) sendEOF() error {
 // It prepends the data with a 4-byte length header in network byte order.
 // Returns an error if any occurred.
 func (conn *CLAMDConn) sendChunk(data []byte) error {
-	var buf [4]byte
+	var header [4]byte
 	lenData := len(data)
-	buf[0] = byte(lenData >> 24)
-	buf[1] = byte(lenData >> 16)
-	buf[2] = byte(lenData >> 8)
-	buf[3] = byte(lenData >> 0)
-
-	a := buf
+	header[0] = byte(lenData >> 24)
+	header[1] = byte(lenData >> 16)
+	header[2] = byte(lenData >> 8)
+	header[3] = byte(lenData >> 0)
 
-	b := make([]byte, len(a))
-	for i := range a {
-		b[i] = a[i]
+	if _, err := conn.Write(header[:]); err != nil {
+		return err
 	}
 
-	conn.Write(b)
-
 	_, err := conn.Write(data)
 	return err
 }
 
 // readResponse reads the response from the ClamAV daemon.
+// It uses a bufio.Scanner capped at maxLineBytes (DefaultMaxLineBytes if
+// CLAMDConn.maxLineBytes is unset) so a misbehaving or malicious clamd
+// can't make the client buffer an unbounded amount of memory on one line;
+// a line beyond that limit ends the response with a RES_PARSE_ERROR
+// instead of growing the buffer forever.
 // It returns a channel of ScanResults, a WaitGroup that will be done when the response is complete,
 // and an error if any occurred.
 func (c *CLAMDConn) readResponse() (chan *ScanResult, *sync.WaitGroup, error) {
 	var wg sync.WaitGroup
 
+	maxLineBytes := c.maxLineBytes
+	if maxLineBytes <= 0 {
+		maxLineBytes = DefaultMaxLineBytes
+	}
+
+	// bufio.Scanner's limit is the larger of its max and the initial
+	// buffer's capacity, so the initial buffer must not exceed maxLineBytes.
+	initialBuf := 4096
+	if maxLineBytes < initialBuf {
+		initialBuf = maxLineBytes
+	}
+
 	wg.Add(1)
-	reader := bufio.NewReader(c)
+	scanner := bufio.NewScanner(c)
+	scanner.Buffer(make([]byte, 0, initialBuf), maxLineBytes)
 	ch := make(chan *ScanResult)
 
 	go func() {
@@ -115,18 +160,16 @@ func (c *CLAMDConn) readResponse() (chan *ScanResult, *sync.WaitGroup, error) {
 			wg.Done()
 		}()
 
-		for {
-			line, err := reader.ReadString('\n')
-			if err == io.EOF {
-				return
-			}
+		for scanner.Scan() {
+			line := strings.TrimRight(scanner.Text(), " \t\r")
+			ch <- parseResult(line)
+		}
 
-			if err != nil {
-				return
+		if err := scanner.Err(); err != nil {
+			ch <- &ScanResult{
+				Status:      RES_PARSE_ERROR,
+				Description: fmt.Sprintf("error reading response: %s", err),
 			}
-
-			line = strings.TrimRight(line, " \t\r\n")
-			ch <- parseResult(line)
 		}
 	}()
 
@@ -162,47 +205,16 @@ func parseResult(line string) *ScanResult {
 			}
 		case "status":
 			switch matches[i] {
-			case RES_OK:
-			case RES_FOUND:
-			case RES_ERROR:
-				break
+			case RES_OK, RES_FOUND, RES_ERROR:
+				res.Status = matches[i]
 			default:
 				res.Description = "Invalid status field: " + matches[i]
 				res.Status = RES_PARSE_ERROR
 				return res
 			}
-			res.Status = matches[i]
 		}
 	}
 
 	return res
 }
 
-// newCLAMDTcpConn creates a new TCP connection to the ClamAV daemon.
-// It dials the specified address with a timeout.
-// Returns a CLAMDConn and an error if any occurred.
-func newCLAMDTcpConn(address string) (*CLAMDConn, error) {
-	conn, err := net.DialTimeout("tcp", address, TCP_TIMEOUT)
-
-	if err != nil {
-		if nerr, isOk := err.(net.Error); isOk && nerr.Timeout() {
-			return nil, nerr
-		}
-
-		return nil, err
-	}
-
-	return &CLAMDConn{Conn: conn}, err
-}
-
-// newCLAMDUnixConn creates a new Unix socket connection to the ClamAV daemon.
-// It dials the specified Unix socket path.
-// Returns a CLAMDConn and an error if any occurred.
-func newCLAMDUnixConn(address string) (*CLAMDConn, error) {
-	conn, err := net.Dial("unix", address)
-	if err != nil {
-		return nil, err
-	}
-
-	return &CLAMDConn{Conn: conn}, err
-}
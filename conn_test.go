@@ -0,0 +1,143 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+
+The MIT License (MIT)
+Copyright (c) 2013 DutchCoders <http://github.com/dutchcoders/>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clamd
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pipeConn wires a CLAMDConn to the server half of an in-memory net.Pipe, so
+// readResponse can be exercised against crafted bytes without a real clamd.
+func pipeConn(t *testing.T, maxLineBytes int) (*CLAMDConn, net.Conn) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	return &CLAMDConn{Conn: client, maxLineBytes: maxLineBytes}, server
+}
+
+func TestParseResultAcceptsKnownStatuses(t *testing.T) {
+	cases := []struct {
+		line   string
+		status string
+	}{
+		{"/tmp/clean: OK", RES_OK},
+		{"/tmp/evil: Eicar-Test-Signature FOUND", RES_FOUND},
+		{"/tmp/missing: Can't access file ERROR", RES_ERROR},
+	}
+
+	for _, c := range cases {
+		res := parseResult(c.line)
+		if res.Status != c.status {
+			t.Errorf("parseResult(%q).Status = %q, want %q", c.line, res.Status, c.status)
+		}
+	}
+}
+
+func TestParseResultRejectsUnknownStatus(t *testing.T) {
+	res := parseResult("/tmp/x: WEIRDSTATUS")
+	if res.Status != RES_PARSE_ERROR {
+		t.Fatalf("expected RES_PARSE_ERROR for an unknown status, got %q", res.Status)
+	}
+}
+
+func TestParseResultRejectsMissingPath(t *testing.T) {
+	res := parseResult("this is not a clamd response")
+	if res.Status != RES_PARSE_ERROR {
+		t.Fatalf("expected RES_PARSE_ERROR for a line with no path/status, got %q", res.Status)
+	}
+}
+
+func TestReadResponseRejectsOversizeLine(t *testing.T) {
+	conn, server := pipeConn(t, 64)
+
+	go func() {
+		server.Write([]byte(strings.Repeat("A", 10*1024)))
+		server.Write([]byte("\n"))
+		server.Close()
+	}()
+
+	ch, wg, err := conn.readResponse()
+	if err != nil {
+		t.Fatalf("readResponse: %v", err)
+	}
+
+	done := make(chan struct{})
+	var results []*ScanResult
+	go func() {
+		for s := range ch {
+			results = append(results, s)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("readResponse did not terminate on an oversize line")
+	}
+	wg.Wait()
+
+	if len(results) != 1 {
+		t.Fatalf("expected exactly one result for an oversize line, got %d", len(results))
+	}
+	if results[0].Status != RES_PARSE_ERROR {
+		t.Fatalf("expected RES_PARSE_ERROR for an oversize line, got %q", results[0].Status)
+	}
+}
+
+func TestReadResponseHandlesMixedValidAndMalformedLines(t *testing.T) {
+	conn, server := pipeConn(t, DefaultMaxLineBytes)
+
+	go func() {
+		server.Write([]byte("/tmp/a: OK\n"))
+		server.Write([]byte("this is not a clamd response\n"))
+		server.Write([]byte("/tmp/b: Eicar-Test-Signature FOUND\n"))
+		server.Close()
+	}()
+
+	ch, wg, err := conn.readResponse()
+	if err != nil {
+		t.Fatalf("readResponse: %v", err)
+	}
+
+	var results []*ScanResult
+	for s := range ch {
+		results = append(results, s)
+	}
+	wg.Wait()
+
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+	if results[0].Status != RES_OK || results[1].Status != RES_PARSE_ERROR || results[2].Status != RES_FOUND {
+		t.Fatalf("unexpected statuses: %q, %q, %q", results[0].Status, results[1].Status, results[2].Status)
+	}
+}
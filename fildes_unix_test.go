@@ -0,0 +1,139 @@
+//go:build !windows
+
+package clamd
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// startFakeFildesClamd runs a minimal clamd stand-in that accepts a single
+// Unix connection, reads the FILDES command along with the SCM_RIGHTS file
+// descriptor that comes with it, and reports whether that file's contents
+// contain EICAR.
+func startFakeFildesClamd(t *testing.T) string {
+	t.Helper()
+
+	sock := filepath.Join(t.TempDir(), "clamd.sock")
+	addr, err := net.ResolveUnixAddr("unix", sock)
+	if err != nil {
+		t.Fatalf("ResolveUnixAddr: %v", err)
+	}
+
+	ln, err := net.ListenUnix("unix", addr)
+	if err != nil {
+		t.Fatalf("ListenUnix: %v", err)
+	}
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		unixConn := conn.(*net.UnixConn)
+
+		buf := make([]byte, 256)
+		oob := make([]byte, 256)
+
+		n, oobn, _, _, err := unixConn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			return
+		}
+
+		cmd := string(buf[:n])
+		if cmd != "nFILDES\n" {
+			conn.Write([]byte("stream: ERROR\n"))
+			return
+		}
+
+		scms, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err != nil || len(scms) == 0 {
+			conn.Write([]byte("stream: ERROR\n"))
+			return
+		}
+
+		fds, err := syscall.ParseUnixRights(&scms[0])
+		if err != nil || len(fds) == 0 {
+			conn.Write([]byte("stream: ERROR\n"))
+			return
+		}
+
+		f := os.NewFile(uintptr(fds[0]), "fildes")
+		defer f.Close()
+
+		content, err := io.ReadAll(f)
+		if err != nil {
+			conn.Write([]byte("stream: ERROR\n"))
+			return
+		}
+
+		if bytes.Contains(content, EICAR) {
+			conn.Write([]byte("fd[0]: Eicar-Test-Signature FOUND\n"))
+		} else {
+			conn.Write([]byte("fd[0]: OK\n"))
+		}
+	}()
+
+	t.Cleanup(func() { ln.Close() })
+
+	return "unix://" + sock
+}
+
+func TestScanFDReportsCleanFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "clean.txt")
+	if err := os.WriteFile(path, []byte("hello, world"), 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	c := NewClamd(startFakeFildesClamd(t))
+
+	ch, err := c.ScanOpenFile(f)
+	if err != nil {
+		t.Fatalf("ScanOpenFile: %v", err)
+	}
+
+	res := <-ch
+	if res.Status != RES_OK {
+		t.Fatalf("expected %s, got %s (raw=%q)", RES_OK, res.Status, res.Raw)
+	}
+}
+
+func TestScanFDReportsEicar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "eicar.txt")
+	if err := os.WriteFile(path, EICAR, 0o600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+
+	c := NewClamd(startFakeFildesClamd(t))
+
+	ch, err := c.ScanOpenFile(f)
+	if err != nil {
+		t.Fatalf("ScanOpenFile: %v", err)
+	}
+
+	res := <-ch
+	if res.Status != RES_FOUND {
+		t.Fatalf("expected %s, got %s (raw=%q)", RES_FOUND, res.Status, res.Raw)
+	}
+}
@@ -0,0 +1,50 @@
+//go:build windows
+
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+
+The MIT License (MIT)
+Copyright (c) 2013 DutchCoders <http://github.com/dutchcoders/>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package clamd provides a client for the ClamAV daemon (clamd).
+// This file stubs out the FILDES command on platforms without SCM_RIGHTS /
+// net.UnixConn support.
+package clamd
+
+import (
+	"errors"
+	"os"
+)
+
+// errFildesUnsupported is returned by ScanFD and ScanOpenFile on platforms
+// that don't support passing file descriptors over Unix sockets.
+var errFildesUnsupported = errors.New("clamd: FILDES is not supported on this platform")
+
+// ScanFD always returns errFildesUnsupported on this platform.
+func (c *Clamd) ScanFD(fd int) (chan *ScanResult, error) {
+	return nil, errFildesUnsupported
+}
+
+// ScanOpenFile always returns errFildesUnsupported on this platform.
+func (c *Clamd) ScanOpenFile(f *os.File) (chan *ScanResult, error) {
+	return nil, errFildesUnsupported
+}
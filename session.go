@@ -0,0 +1,342 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+
+The MIT License (MIT)
+Copyright (c) 2013 DutchCoders <http://github.com/dutchcoders/>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package clamd provides a client for the ClamAV daemon (clamd).
+// This file implements IDSESSION pipelining: a single connection over which
+// many "z"-prefixed commands are sent back to back, with clamd replying
+// "<id>: <result>\0" for each in the order it finishes them.
+package clamd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sessionResultRegex extracts the 1-based request id clamd prefixes onto
+// every reply sent inside an IDSESSION, e.g. "3: /tmp/x: OK".
+var sessionResultRegex = regexp.MustCompile(`^(?P<id>\d+): (?P<result>.*)$`)
+
+// bareSessionReplies maps the handful of clamd control replies that don't
+// follow parseResult's "path: status" shape onto a usable Status, so a
+// caller checking Status (rather than Raw) doesn't see a spurious
+// RES_PARSE_ERROR for an otherwise healthy PING/RELOAD inside a session.
+var bareSessionReplies = map[string]string{
+	"PONG":      RES_OK,
+	"RELOADING": RES_OK,
+}
+
+// parseSessionResult parses a single IDSESSION reply (with the "<id>: "
+// prefix already stripped). Known bare replies like PONG and RELOADING are
+// reported with Status set directly; everything else falls back to
+// parseResult's "path: status" parsing.
+func parseSessionResult(result string) *ScanResult {
+	if status, ok := bareSessionReplies[result]; ok {
+		return &ScanResult{Raw: result, Status: status}
+	}
+	return parseResult(result)
+}
+
+// ErrSessionClosed is returned by Session methods, and delivered to any
+// outstanding result channels, once Close has been called or clamd has
+// closed the connection. A session that fails on any other read error or
+// on a malformed reply reports that error instead.
+var ErrSessionClosed = errors.New("clamd: session closed")
+
+// Session represents a single clamd connection in IDSESSION mode. Unlike the
+// one-shot commands on Clamd, a Session lets many commands be pipelined over
+// one socket: each call enqueues its command and returns immediately with a
+// channel that receives clamd's reply once it arrives, without waiting for
+// replies to commands enqueued ahead of it.
+//
+// Session methods may be called concurrently: writeMu serializes id
+// allocation with the actual write to the socket, so the order commands
+// hit the wire always matches the order their ids were handed out in,
+// which is what lets clamd's "<id>: <result>" replies be routed back to
+// the right caller. One consequence is that ScanStream holds writeMu for
+// the whole upload, so other Session calls block behind a slow stream
+// until it finishes sending.
+type Session struct {
+	conn *CLAMDConn
+
+	// writeMu serializes id allocation with writing that command (or
+	// stream) to the socket, so on-wire order matches assigned id order.
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	nextID   uint64
+	pending  map[uint64]chan *ScanResult
+	closed   bool
+	closeErr error
+
+	// done is closed by Close, so the context watcher goroutine spawned by
+	// NewSession can exit on a direct Close call instead of leaking until
+	// ctx is eventually done (which, for context.Background(), is never).
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+// NewSession opens a new connection to the ClamAV daemon and puts it into
+// IDSESSION mode. The returned Session remains valid, and may be used
+// concurrently, until Close is called or clamd terminates the session
+// because of a protocol error.
+func (c *Clamd) NewSession(ctx context.Context) (*Session, error) {
+	conn, err := c.newConnection()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.sendZCommand("IDSESSION"); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	s := &Session{
+		conn:    conn,
+		pending: make(map[uint64]chan *ScanResult),
+		done:    make(chan struct{}),
+	}
+
+	go s.readLoop()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}()
+
+	return s, nil
+}
+
+// enqueue assigns the next request id, registers a result channel for it,
+// and sends command framed as a z-command. writeMu keeps the id allocation
+// and the write to the socket atomic with respect to other callers, so
+// on-wire order matches assigned id order.
+// Returns the channel that will receive the single reply clamd sends back
+// for this id.
+func (s *Session) enqueue(command string) (chan *ScanResult, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	id, ch, err := s.register()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.conn.sendZCommand(command); err != nil {
+		s.drop(id)
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// register allocates the next request id and its result channel without
+// sending anything yet, so callers that need to stream data (ScanStream)
+// can reserve their place in line before writing to the socket.
+func (s *Session) register() (uint64, chan *ScanResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, nil, s.closeErr
+	}
+
+	s.nextID++
+	id := s.nextID
+	ch := make(chan *ScanResult, 1)
+	s.pending[id] = ch
+
+	return id, ch, nil
+}
+
+// drop removes a request id that was registered but never answered, e.g.
+// because writing its command failed.
+func (s *Session) drop(id uint64) {
+	s.mu.Lock()
+	delete(s.pending, id)
+	s.mu.Unlock()
+}
+
+// Ping checks the daemon's state inside the session.
+// Returns a channel that receives a result with Status RES_OK and
+// Raw "PONG" on success, or an error result otherwise.
+func (s *Session) Ping() (chan *ScanResult, error) {
+	return s.enqueue("PING")
+}
+
+// ScanFile scans a file or directory (recursively) with archive support
+// enabled. It requires a full path to the file or directory.
+// Returns a channel that receives the scan result.
+func (s *Session) ScanFile(path string) (chan *ScanResult, error) {
+	return s.enqueue(fmt.Sprintf("SCAN %s", path))
+}
+
+// MultiScanFile scans a file or directory (recursively) using multiple
+// threads. Returns a channel that receives the scan result.
+func (s *Session) MultiScanFile(path string) (chan *ScanResult, error) {
+	return s.enqueue(fmt.Sprintf("MULTISCAN %s", path))
+}
+
+// ContScanFile scans a file or directory (recursively) without stopping at
+// the first match. Returns a channel that receives the scan result.
+func (s *Session) ContScanFile(path string) (chan *ScanResult, error) {
+	return s.enqueue(fmt.Sprintf("CONTSCAN %s", path))
+}
+
+// ScanStream scans a stream of data inside the session. As with
+// Clamd.ScanStream, the data is sent in <length><data> chunks terminated by
+// a zero-length chunk; unlike Clamd.ScanStream this reuses the session's
+// existing connection, so other commands can be pipelined around it.
+// writeMu is held for the id allocation and the entire write of the
+// stream, so a concurrent enqueue can't interleave its command into the
+// middle of this stream's chunks; other Session calls block until the
+// stream finishes sending.
+// Returns a channel that receives the scan result once clamd has consumed
+// the whole stream.
+func (s *Session) ScanStream(r io.Reader) (chan *ScanResult, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	id, ch, err := s.register()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.conn.sendZCommand("INSTREAM"); err != nil {
+		s.drop(id)
+		return nil, err
+	}
+
+	for {
+		buf := make([]byte, CHUNK_SIZE)
+
+		nr, rerr := r.Read(buf)
+		if nr > 0 {
+			if werr := s.conn.sendChunk(buf[0:nr]); werr != nil {
+				s.drop(id)
+				return nil, werr
+			}
+		}
+
+		if rerr != nil {
+			break
+		}
+	}
+
+	if err := s.conn.sendEOF(); err != nil {
+		s.drop(id)
+		return nil, err
+	}
+
+	return ch, nil
+}
+
+// Close terminates the session's connection and fails any outstanding
+// result channels with ErrSessionClosed. It also signals the context
+// watcher goroutine started by NewSession to exit, so calling Close
+// directly (rather than waiting for ctx to be done) doesn't leak it.
+func (s *Session) Close() error {
+	s.doneOnce.Do(func() { close(s.done) })
+	s.failAll(ErrSessionClosed)
+	return s.conn.Close()
+}
+
+// readLoop reads clamd's "<id>: <result>\0" replies off the wire and
+// dispatches each to the channel registered for that id. It runs until the
+// connection is closed or clamd terminates the session on a protocol error,
+// at which point it fails every request still waiting on a reply.
+func (s *Session) readLoop() {
+	reader := bufio.NewReader(s.conn)
+
+	for {
+		line, err := reader.ReadString(0)
+		if err != nil {
+			if err == io.EOF {
+				s.failAll(ErrSessionClosed)
+			} else {
+				s.failAll(err)
+			}
+			return
+		}
+
+		line = strings.TrimRight(line, "\x00")
+
+		matches := sessionResultRegex.FindStringSubmatch(line)
+		if matches == nil {
+			// clamd is expected to prefix every reply with "<id>: "; a line
+			// that doesn't match means the session protocol has gone out of
+			// sync, so treat it the same as clamd dropping the connection.
+			s.failAll(fmt.Errorf("clamd: malformed IDSESSION reply: %q", line))
+			return
+		}
+
+		id, err := strconv.ParseUint(matches[1], 10, 64)
+		if err != nil {
+			s.failAll(fmt.Errorf("clamd: malformed IDSESSION id: %q", line))
+			return
+		}
+
+		s.mu.Lock()
+		ch, ok := s.pending[id]
+		delete(s.pending, id)
+		s.mu.Unlock()
+
+		if !ok {
+			continue
+		}
+
+		ch <- parseSessionResult(matches[2])
+		close(ch)
+	}
+}
+
+// failAll marks the session closed and delivers err to every request still
+// waiting for a reply.
+func (s *Session) failAll(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+	s.closeErr = err
+
+	for id, ch := range s.pending {
+		ch <- &ScanResult{Status: RES_ERROR, Description: err.Error()}
+		close(ch)
+		delete(s.pending, id)
+	}
+}
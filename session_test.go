@@ -0,0 +1,55 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+
+The MIT License (MIT)
+Copyright (c) 2013 DutchCoders <http://github.com/dutchcoders/>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+package clamd
+
+import "testing"
+
+func TestParseSessionResultAcceptsBareControlReplies(t *testing.T) {
+	cases := []struct {
+		result string
+		status string
+	}{
+		{"PONG", RES_OK},
+		{"RELOADING", RES_OK},
+	}
+
+	for _, c := range cases {
+		res := parseSessionResult(c.result)
+		if res.Status != c.status {
+			t.Errorf("parseSessionResult(%q).Status = %q, want %q", c.result, res.Status, c.status)
+		}
+		if res.Raw != c.result {
+			t.Errorf("parseSessionResult(%q).Raw = %q, want %q", c.result, res.Raw, c.result)
+		}
+	}
+}
+
+func TestParseSessionResultFallsBackToParseResult(t *testing.T) {
+	res := parseSessionResult("/tmp/evil: Eicar-Test-Signature FOUND")
+	if res.Status != RES_FOUND {
+		t.Fatalf("expected %s, got %s", RES_FOUND, res.Status)
+	}
+}
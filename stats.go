@@ -0,0 +1,207 @@
+/*
+Open Source Initiative OSI - The MIT License (MIT):Licensing
+
+The MIT License (MIT)
+Copyright (c) 2013 DutchCoders <http://github.com/dutchcoders/>
+
+Permission is hereby granted, free of charge, to any person obtaining a copy of
+this software and associated documentation files (the "Software"), to deal in
+the Software without restriction, including without limitation the rights to
+use, copy, modify, merge, publish, distribute, sublicense, and/or sell copies
+of the Software, and to permit persons to whom the Software is furnished to do
+so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in all
+copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+SOFTWARE.
+*/
+
+// Package clamd provides a client for the ClamAV daemon (clamd).
+// This file parses clamd's STATS response lines into the typed fields on
+// Stats, and renders those fields in Prometheus text exposition format.
+package clamd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// threadsRegex extracts the live/idle/max thread counts and the idle
+// timeout (in seconds) from a THREADS line.
+var threadsRegex = regexp.MustCompile(
+	`live (\d+)\s+idle (\d+)\s*max (\d+)\s+idle-timeout (\d+)`,
+)
+
+// queueItemsRegex extracts the item count from a QUEUE line.
+var queueItemsRegex = regexp.MustCompile(`QUEUE:\s*(\d+) items`)
+
+// queueAgeRegex extracts the queue age (in seconds) from the "STATS
+// <seconds>" line clamd prints immediately after QUEUE.
+var queueAgeRegex = regexp.MustCompile(`^STATS ([\d.]+)`)
+
+// memstatsRegex extracts the heap/mmap/used/free/releasable sizes (in MiB)
+// and the pool count/sizes from a MEMSTATS line.
+var memstatsRegex = regexp.MustCompile(
+	`heap ([\d.]+)M mmap ([\d.]+)M used ([\d.]+)M free ([\d.]+)M releasable ([\d.]+)M pools (\d+) pools_used ([\d.]+)M pools_total ([\d.]+)M`,
+)
+
+// poolHeaderRegex matches a per-pool section header, e.g. "PRIMARY POOL:".
+var poolHeaderRegex = regexp.MustCompile(`^(\S+ POOL):\s*(.*)$`)
+
+// poolStateRegex matches the STATE line within a pool section.
+var poolStateRegex = regexp.MustCompile(`^STATE:\s*(.*)$`)
+
+// poolThreadRegex matches a per-thread "<task> age: <seconds>" entry within
+// a pool section.
+var poolThreadRegex = regexp.MustCompile(`^\s*(\S+)\s+age:\s*([\d.]+)s?$`)
+
+// parseThreadStats parses a THREADS line into a ThreadStats. It returns the
+// zero value if raw does not match the expected format.
+func parseThreadStats(raw string) ThreadStats {
+	m := threadsRegex.FindStringSubmatch(raw)
+	if m == nil {
+		return ThreadStats{}
+	}
+
+	live, _ := strconv.Atoi(m[1])
+	idle, _ := strconv.Atoi(m[2])
+	max, _ := strconv.Atoi(m[3])
+	timeout, _ := strconv.Atoi(m[4])
+
+	return ThreadStats{
+		Live:        live,
+		Idle:        idle,
+		Max:         max,
+		IdleTimeout: time.Duration(timeout) * time.Second,
+	}
+}
+
+// parseQueueStats scans every raw STATS response line for the QUEUE item
+// count and its STATS continuation line, returning them as a QueueStats.
+func parseQueueStats(lines []string) QueueStats {
+	var q QueueStats
+
+	for _, line := range lines {
+		if m := queueItemsRegex.FindStringSubmatch(line); m != nil {
+			q.Items, _ = strconv.Atoi(m[1])
+			continue
+		}
+
+		if m := queueAgeRegex.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			if age, err := strconv.ParseFloat(m[1], 64); err == nil {
+				q.Age = time.Duration(age * float64(time.Second))
+			}
+		}
+	}
+
+	return q
+}
+
+// parseMemStats parses a MEMSTATS line into a MemStats. It returns the zero
+// value if raw does not match the expected format.
+func parseMemStats(raw string) MemStats {
+	m := memstatsRegex.FindStringSubmatch(raw)
+	if m == nil {
+		return MemStats{}
+	}
+
+	f := func(s string) float64 {
+		v, _ := strconv.ParseFloat(s, 64)
+		return v
+	}
+	pools, _ := strconv.Atoi(m[6])
+
+	return MemStats{
+		HeapMiB:       f(m[1]),
+		MmapMiB:       f(m[2]),
+		UsedMiB:       f(m[3]),
+		FreeMiB:       f(m[4]),
+		ReleasableMiB: f(m[5]),
+		Pools:         pools,
+		PoolsUsedMiB:  f(m[7]),
+		PoolsTotalMiB: f(m[8]),
+	}
+}
+
+// parsePoolStats scans every raw STATS response line for per-pool sections
+// (e.g. "PRIMARY POOL: ...") and returns one Pool per section found, with
+// its STATE and any per-thread task/age entries.
+func parsePoolStats(lines []string) []Pool {
+	var pools []Pool
+	var current *Pool
+
+	flush := func() {
+		if current != nil {
+			pools = append(pools, *current)
+			current = nil
+		}
+	}
+
+	for _, line := range lines {
+		if m := poolHeaderRegex.FindStringSubmatch(line); m != nil {
+			flush()
+			current = &Pool{Name: strings.TrimSpace(m[1])}
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		if m := poolStateRegex.FindStringSubmatch(line); m != nil {
+			current.State = strings.TrimSpace(m[1])
+			continue
+		}
+
+		if m := poolThreadRegex.FindStringSubmatch(line); m != nil {
+			age, _ := strconv.ParseFloat(m[2], 64)
+			current.Threads = append(current.Threads, PoolThread{
+				Task: m[1],
+				Age:  time.Duration(age * float64(time.Second)),
+			})
+		}
+	}
+	flush()
+
+	return pools
+}
+
+// PrometheusMetrics renders s in Prometheus text exposition format, so a
+// caller can serve it directly from a metrics endpoint (or feed it into a
+// prometheus.Collector) without writing its own STATS parsing.
+func (s *Stats) PrometheusMetrics() string {
+	var b strings.Builder
+
+	gauge := func(name, help string, value float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n%s %v\n", name, help, name, name, value)
+	}
+
+	gauge("clamd_threads_live", "Number of live scanner threads.", float64(s.ThreadStats.Live))
+	gauge("clamd_threads_idle", "Number of idle scanner threads.", float64(s.ThreadStats.Idle))
+	gauge("clamd_threads_max", "Maximum number of scanner threads.", float64(s.ThreadStats.Max))
+	gauge("clamd_threads_idle_timeout_seconds", "Idle thread timeout, in seconds.", s.ThreadStats.IdleTimeout.Seconds())
+
+	gauge("clamd_queue_items", "Number of items currently in the scan queue.", float64(s.QueueStats.Items))
+	gauge("clamd_queue_age_seconds", "Age of the oldest item in the scan queue, in seconds.", s.QueueStats.Age.Seconds())
+
+	gauge("clamd_mem_heap_mib", "Heap memory in use, in MiB.", s.Mem.HeapMiB)
+	gauge("clamd_mem_mmap_mib", "Mmap'd memory in use, in MiB.", s.Mem.MmapMiB)
+	gauge("clamd_mem_used_mib", "Total memory in use, in MiB.", s.Mem.UsedMiB)
+	gauge("clamd_mem_free_mib", "Free memory, in MiB.", s.Mem.FreeMiB)
+	gauge("clamd_mem_releasable_mib", "Memory that could be released back to the OS, in MiB.", s.Mem.ReleasableMiB)
+	gauge("clamd_mem_pools", "Number of memory pools.", float64(s.Mem.Pools))
+	gauge("clamd_mem_pools_used_mib", "Memory in use across all pools, in MiB.", s.Mem.PoolsUsedMiB)
+	gauge("clamd_mem_pools_total_mib", "Total memory allocated across all pools, in MiB.", s.Mem.PoolsTotalMiB)
+
+	return b.String()
+}